@@ -0,0 +1,230 @@
+/*
+ * Copyright (c) 2018 Miguel Ángel Ortuño.
+ * See the LICENSE file for more information.
+ */
+
+package cluster
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// NodeEventKind identifies the kind of node membership event being audited.
+type NodeEventKind string
+
+const (
+	// NodeEventJoined is emitted when a node joins the cluster.
+	NodeEventJoined NodeEventKind = "joined"
+	// NodeEventUpdated is emitted when a node's metadata changes.
+	NodeEventUpdated NodeEventKind = "updated"
+	// NodeEventLeft is emitted when a node leaves the cluster.
+	NodeEventLeft NodeEventKind = "left"
+)
+
+// MessageDirection identifies whether an audited message was sent or
+// received by the local node.
+type MessageDirection string
+
+const (
+	// MessageDirectionOutbound identifies a message sent to a peer.
+	MessageDirectionOutbound MessageDirection = "outbound"
+	// MessageDirectionInbound identifies a message received from a peer.
+	MessageDirectionInbound MessageDirection = "inbound"
+)
+
+// AuditEmitter is implemented by anything that records cluster node and
+// message events for later inspection. Implementations must be safe for
+// concurrent use.
+type AuditEmitter interface {
+	// EmitNodeEvent records a node membership change.
+	EmitNodeEvent(ctx context.Context, kind NodeEventKind, node string)
+
+	// EmitMessageEvent records a message send or receive. err is the
+	// outcome of the send (nil on success); msg's payload is never
+	// recorded, only its type and byte length.
+	EmitMessageEvent(ctx context.Context, direction MessageDirection, msg *Message, peer string, err error)
+}
+
+// AuditEvent is the persisted, replayable shape of a single audit record.
+type AuditEvent struct {
+	Seq       uint64           `json:"seq"`
+	Time      time.Time        `json:"time"`
+	LocalNode string           `json:"local_node"`
+	Kind      string           `json:"kind"`
+	Node      string           `json:"node,omitempty"`
+	NodeKind  NodeEventKind    `json:"node_kind,omitempty"`
+	Direction MessageDirection `json:"direction,omitempty"`
+	Peer      string           `json:"peer,omitempty"`
+	MsgType   *MessageType     `json:"msg_type,omitempty"`
+	MsgBytes  int              `json:"msg_bytes,omitempty"`
+	Error     string           `json:"error,omitempty"`
+}
+
+const (
+	auditKindNode    = "node"
+	auditKindMessage = "message"
+)
+
+// discardAuditEmitter is a no-op AuditEmitter, used when Config.AuditEmitter
+// is left nil.
+type discardAuditEmitter struct{}
+
+// NewDiscardAuditEmitter returns an AuditEmitter that drops every event.
+func NewDiscardAuditEmitter() AuditEmitter { return discardAuditEmitter{} }
+
+func (discardAuditEmitter) EmitNodeEvent(context.Context, NodeEventKind, string) {}
+func (discardAuditEmitter) EmitMessageEvent(context.Context, MessageDirection, *Message, string, error) {
+}
+
+// FileAuditEmitter appends newline-delimited JSON audit events to a file,
+// rotating it once it grows past maxSizeBytes.
+type FileAuditEmitter struct {
+	localNode    string
+	maxSizeBytes int64
+
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	size int64
+	seq  uint64
+}
+
+// NewFileAuditEmitter opens (creating if needed) path for append and
+// returns an emitter that writes one JSON object per line. Before a write
+// that would take the current file past maxSizeBytes, the file is renamed
+// to path.<unix-nanosecond timestamp> and a fresh one is opened at path. A
+// maxSizeBytes <= 0 disables rotation.
+func NewFileAuditEmitter(localNode, path string, maxSizeBytes int64) (*FileAuditEmitter, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &FileAuditEmitter{
+		localNode:    localNode,
+		maxSizeBytes: maxSizeBytes,
+		path:         path,
+		f:            f,
+		size:         info.Size(),
+	}, nil
+}
+
+// EmitNodeEvent implements AuditEmitter.
+func (e *FileAuditEmitter) EmitNodeEvent(ctx context.Context, kind NodeEventKind, node string) {
+	e.write(AuditEvent{
+		Kind:     auditKindNode,
+		Node:     node,
+		NodeKind: kind,
+	})
+}
+
+// EmitMessageEvent implements AuditEmitter.
+func (e *FileAuditEmitter) EmitMessageEvent(ctx context.Context, direction MessageDirection, msg *Message, peer string, err error) {
+	ev := AuditEvent{
+		Kind:      auditKindMessage,
+		Direction: direction,
+		Peer:      peer,
+		MsgBytes:  len(msg.Payload),
+	}
+	typ := msg.Type
+	ev.MsgType = &typ
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	e.write(ev)
+}
+
+func (e *FileAuditEmitter) write(ev AuditEvent) {
+	ev.Seq = atomic.AddUint64(&e.seq, 1)
+	ev.Time = time.Now()
+	ev.LocalNode = e.localNode
+
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.maxSizeBytes > 0 && e.size+int64(len(b)) > e.maxSizeBytes {
+		e.rotateLocked()
+	}
+	n, werr := e.f.Write(b)
+	if werr == nil {
+		e.size += int64(n)
+	}
+}
+
+func (e *FileAuditEmitter) rotateLocked() {
+	_ = e.f.Close()
+	rotated := fmt.Sprintf("%s.%d", e.path, time.Now().UnixNano())
+	_ = os.Rename(e.path, rotated)
+
+	f, err := os.OpenFile(e.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return
+	}
+	e.f = f
+	e.size = 0
+}
+
+// Close flushes and fsyncs the underlying file before closing it.
+func (e *FileAuditEmitter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.f.Sync(); err != nil {
+		e.f.Close()
+		return err
+	}
+	return e.f.Close()
+}
+
+// AuditLogIterator replays the events of a file-backed audit log in order.
+type AuditLogIterator struct {
+	f   *os.File
+	dec *bufio.Scanner
+}
+
+// OpenAuditLog opens path for replay, one AuditEvent at a time via Next.
+func OpenAuditLog(path string) (*AuditLogIterator, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLogIterator{f: f, dec: bufio.NewScanner(f)}, nil
+}
+
+// Next returns the next event in the log, or io.EOF once exhausted.
+func (it *AuditLogIterator) Next() (*AuditEvent, error) {
+	if !it.dec.Scan() {
+		if err := it.dec.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	var ev AuditEvent
+	if err := json.Unmarshal(it.dec.Bytes(), &ev); err != nil {
+		return nil, err
+	}
+	return &ev, nil
+}
+
+// Close releases the underlying file handle.
+func (it *AuditLogIterator) Close() error {
+	return it.f.Close()
+}
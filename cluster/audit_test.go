@@ -0,0 +1,74 @@
+/*
+ * Copyright (c) 2018 Miguel Ángel Ortuño.
+ * See the LICENSE file for more information.
+ */
+
+package cluster
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileAuditEmitter_EmitAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	e, err := NewFileAuditEmitter("node-1", path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	e.EmitNodeEvent(ctx, NodeEventJoined, "node-2")
+	e.EmitMessageEvent(ctx, MessageDirectionOutbound, &Message{Type: MessageTypeStanza, Payload: []byte("hi")}, "node-2", nil)
+	e.EmitMessageEvent(ctx, MessageDirectionOutbound, &Message{Type: MessageTypeStanza}, "node-2", errors.New("boom"))
+
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	it, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	var events []*AuditEvent
+	for {
+		ev, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, ev)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 replayed events, got %d", len(events))
+	}
+	if events[0].Kind != auditKindNode || events[0].Node != "node-2" {
+		t.Fatalf("unexpected first event: %+v", events[0])
+	}
+	if events[1].MsgBytes != 2 {
+		t.Fatalf("expected payload length 2 to be recorded, got %d", events[1].MsgBytes)
+	}
+	if events[2].Error != "boom" {
+		t.Fatalf("expected send error to be recorded, got %q", events[2].Error)
+	}
+	for _, ev := range events {
+		if ev.LocalNode != "node-1" {
+			t.Fatalf("expected local node node-1, got %q", ev.LocalNode)
+		}
+	}
+}
+
+func TestDiscardAuditEmitter(t *testing.T) {
+	e := NewDiscardAuditEmitter()
+	// exercising the no-op emitter should never panic.
+	e.EmitNodeEvent(context.Background(), NodeEventJoined, "node-2")
+	e.EmitMessageEvent(context.Background(), MessageDirectionInbound, &Message{}, "node-2", nil)
+}
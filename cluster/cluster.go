@@ -11,6 +11,7 @@ import (
 	"errors"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -43,6 +44,21 @@ type Delegate interface {
 	NodeLeft(ctx context.Context, node *Node)
 
 	NotifyMessage(ctx context.Context, msg *Message)
+
+	// FullStateResync is invoked when a peer's outbound queue has grown
+	// past Config.MaxQueueDepth, signaling that seq-based replay can no
+	// longer guarantee delivery and the node needs a full state refresh.
+	FullStateResync(ctx context.Context, node string)
+
+	// LeaderChanged is invoked, in Raft consistency mode, each time the
+	// local node gains or loses Raft leadership, with the Raft address of
+	// the leader known at that moment (empty when none is known). A
+	// leadership change between two other nodes is not reported.
+	LeaderChanged(node string)
+
+	// BecameLeader is invoked, in Raft consistency mode, when the local
+	// node becomes the Raft leader.
+	BecameLeader(ctx context.Context)
 }
 
 // memberList interface defines the common c2s member list methods.
@@ -58,12 +74,20 @@ type memberList interface {
 // Cluster represents a c2s sub system.
 type Cluster struct {
 	cfg        *Config
-	buf        *bytes.Buffer
 	delegate   Delegate
 	memberList memberList
 	membersMu  sync.RWMutex
 	members    map[string]*Node
+	leftNodes  map[string]bool
 	runQueue   *runqueue.RunQueue
+
+	seq     uint64
+	queueMu sync.Mutex
+	queues  map[string]*outboundQueue
+
+	raft *raftNode
+
+	audit AuditEmitter
 }
 
 // New returns an initialized c2s instance
@@ -71,21 +95,58 @@ func New(config *Config, delegate Delegate) (*Cluster, error) {
 	if config == nil {
 		return nil, nil
 	}
+	audit := config.AuditEmitter
+	if audit == nil {
+		audit = NewDiscardAuditEmitter()
+	}
 	c := &Cluster{
-		cfg:      config,
-		delegate: delegate,
-		buf:      bytes.NewBuffer(nil),
-		members:  make(map[string]*Node),
-		runQueue: runqueue.New("cluster"),
+		cfg:       config,
+		delegate:  delegate,
+		members:   make(map[string]*Node),
+		leftNodes: make(map[string]bool),
+		runQueue:  runqueue.New("cluster"),
+		queues:    make(map[string]*outboundQueue),
+		audit:     audit,
 	}
 	ml, err := createMemberList(config.Name, config.BindPort, config.InTimeout, c)
 	if err != nil {
 		return nil, err
 	}
 	c.memberList = ml
+
+	if config.ConsistencyMode == ConsistencyModeRaft {
+		rn, err := newRaftNode(config.Name, &config.Raft, delegate)
+		if err != nil {
+			return nil, err
+		}
+		c.raft = rn
+	}
 	return c, nil
 }
 
+// Propose submits a state-machine command to the Raft consistency layer and
+// blocks until it's been committed to a majority of nodes. It returns
+// ErrConsistencyModeNotEnabled when the cluster wasn't configured with
+// Config.ConsistencyMode = ConsistencyModeRaft.
+func (c *Cluster) Propose(ctx context.Context, key string, value []byte) error {
+	if c.raft == nil {
+		return ErrConsistencyModeNotEnabled
+	}
+	return c.raft.propose(ctx, key, value)
+}
+
+// Get performs a linearizable read of key from the Raft-backed store: it
+// verifies local Raft leadership before reading, so a stale or
+// partitioned former leader returns an error rather than stale data. It
+// returns ErrConsistencyModeNotEnabled when the cluster wasn't configured
+// with Config.ConsistencyMode = ConsistencyModeRaft.
+func (c *Cluster) Get(key string) ([]byte, error) {
+	if c.raft == nil {
+		return nil, ErrConsistencyModeNotEnabled
+	}
+	return c.raft.get(key)
+}
+
 // Join tries to join the c2s by contacting all the given hosts.
 func (c *Cluster) Join() error {
 	log.Infof("local node: %s", c.LocalNode())
@@ -135,18 +196,24 @@ func (c *Cluster) BroadcastMessage(ctx context.Context, msg *Message) {
 func (c *Cluster) Shutdown() error {
 	errCh := make(chan error, 1)
 	c.runQueue.Stop(func() {
+		if c.raft != nil {
+			if err := c.raft.shutdown(); err != nil {
+				log.Error(err)
+			}
+		}
 		errCh <- c.memberList.Shutdown()
 	})
 	return <-errCh
 }
 
-func (c *Cluster) send(_ context.Context, msg *Message, toNode string) error {
-	return c.memberList.SendReliable(toNode, c.encodeMessage(msg))
+func (c *Cluster) send(ctx context.Context, msg *Message, toNode string) error {
+	c.enqueue(ctx, msg, toNode)
+	err := c.memberList.SendReliable(toNode, c.encodeMessage(msg))
+	c.audit.EmitMessageEvent(ctx, MessageDirectionOutbound, msg, toNode, err)
+	return err
 }
 
-func (c *Cluster) broadcast(_ context.Context, msg *Message) error {
-	msgBytes := c.encodeMessage(msg)
-
+func (c *Cluster) broadcast(ctx context.Context, msg *Message) error {
 	c.membersMu.RLock()
 	defer c.membersMu.RUnlock()
 
@@ -156,18 +223,23 @@ func (c *Cluster) broadcast(_ context.Context, msg *Message) error {
 	var wg sync.WaitGroup
 	for _, node := range c.members {
 		wg.Add(1)
-		go func(node string, b []byte) {
+		go func(node string) {
 			defer wg.Done()
 
 			if node == c.LocalNode() {
 				return
 			}
-			if err := c.memberList.SendReliable(node, b); err != nil {
+			// Each destination gets its own copy: send mutates Seq and
+			// every peer's outbound queue must keep the seq it was
+			// actually stamped with, not whichever goroutine wrote it
+			// last into a shared struct.
+			m := *msg
+			if err := c.send(ctx, &m, node); err != nil {
 				errsMu.Lock()
 				errs = append(errs, err)
 				errsMu.Unlock()
 			}
-		}(node.Name, msgBytes)
+		}(node.Name)
 	}
 	wg.Wait()
 
@@ -184,15 +256,68 @@ func (c *Cluster) broadcast(_ context.Context, msg *Message) error {
 	return nil
 }
 
+// enqueue stamps msg with the next monotonic seq for toNode and buffers it
+// in that peer's outbound queue before it's handed to the memberlist, so it
+// can be replayed if toNode drops and rejoins before acking it.
+func (c *Cluster) enqueue(ctx context.Context, msg *Message, toNode string) {
+	msg.Seq = atomic.AddUint64(&c.seq, 1)
+
+	q := c.queueFor(toNode)
+	if !q.push(msg) {
+		log.Errorf("cluster: outbound queue to %s exceeded max depth, requesting full resync", toNode)
+		if c.delegate != nil {
+			c.delegate.FullStateResync(ctx, toNode)
+		}
+	}
+}
+
+func (c *Cluster) queueFor(node string) *outboundQueue {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+
+	q, ok := c.queues[node]
+	if !ok {
+		q = newOutboundQueue(c.cfg.MaxQueueDepth)
+		c.queues[node] = q
+	}
+	return q
+}
+
+// replayTo resends every buffered, unacked message to node, in seq order.
+func (c *Cluster) replayTo(node string) {
+	q := c.queueFor(node)
+	for _, msg := range q.replay(0) {
+		if err := c.memberList.SendReliable(node, c.encodeMessage(msg)); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// sendAck sends a cumulative ack for every message received from node up to
+// and including seq.
+func (c *Cluster) sendAck(node string, seq uint64) {
+	ack := &Message{Type: MessageTypeAck, Node: c.LocalNode(), Seq: seq}
+	if err := c.memberList.SendReliable(node, c.encodeMessage(ack)); err != nil {
+		log.Error(err)
+	}
+}
+
 func (c *Cluster) handleNotifyJoin(ctx context.Context, n *Node) {
 	if n.Name == c.LocalNode() {
 		return
 	}
 	c.membersMu.Lock()
 	c.members[n.Name] = n
+	rejoined := c.leftNodes[n.Name]
+	delete(c.leftNodes, n.Name)
 	c.membersMu.Unlock()
 
 	log.Infof("registered cluster node: %s", n.Name)
+	c.audit.EmitNodeEvent(ctx, NodeEventJoined, n.Name)
+	if rejoined {
+		log.Infof("replaying unacked messages to rejoined node: %s", n.Name)
+		c.replayTo(n.Name)
+	}
 	if c.delegate != nil && n.Name != c.LocalNode() {
 		c.delegate.NodeJoined(ctx, n)
 	}
@@ -207,6 +332,7 @@ func (c *Cluster) handleNotifyUpdate(ctx context.Context, n *Node) {
 	c.membersMu.Unlock()
 
 	log.Infof("updated cluster node: %s", n.Name)
+	c.audit.EmitNodeEvent(ctx, NodeEventUpdated, n.Name)
 	if c.delegate != nil && n.Name != c.LocalNode() {
 		c.delegate.NodeUpdated(ctx, n)
 	}
@@ -218,9 +344,11 @@ func (c *Cluster) handleNotifyLeave(ctx context.Context, n *Node) {
 	}
 	c.membersMu.Lock()
 	delete(c.members, n.Name)
+	c.leftNodes[n.Name] = true
 	c.membersMu.Unlock()
 
 	log.Infof("unregistered cluster node: %s", n.Name)
+	c.audit.EmitNodeEvent(ctx, NodeEventLeft, n.Name)
 	if c.delegate != nil && n.Name != c.LocalNode() {
 		c.delegate.NodeLeft(ctx, n)
 	}
@@ -236,16 +364,22 @@ func (c *Cluster) handleNotifyMsg(ctx context.Context, msg []byte) {
 		log.Error(err)
 		return
 	}
+	c.audit.EmitMessageEvent(ctx, MessageDirectionInbound, &m, m.Node, nil)
+	if m.Type == MessageTypeAck {
+		c.queueFor(m.Node).ack(m.Seq)
+		return
+	}
+	c.sendAck(m.Node, m.Seq)
 	if c.delegate != nil {
 		c.delegate.NotifyMessage(ctx, &m)
 	}
 }
 
 func (c *Cluster) encodeMessage(msg *Message) []byte {
-	defer c.buf.Reset()
-
-	_ = msg.ToBytes(c.buf)
-	msgBytes := make([]byte, c.buf.Len(), c.buf.Len())
-	copy(msgBytes, c.buf.Bytes())
-	return msgBytes
+	// encodeMessage is called concurrently from broadcast's per-peer
+	// goroutines, so each call gets its own buffer rather than sharing
+	// one across the Cluster instance.
+	var buf bytes.Buffer
+	_ = msg.ToBytes(&buf)
+	return buf.Bytes()
 }
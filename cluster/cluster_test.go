@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2018 Miguel Ángel Ortuño.
+ * See the LICENSE file for more information.
+ */
+
+package cluster
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/ortuman/jackal/runqueue"
+)
+
+// mockMemberList is a minimal memberList used to simulate a peer dropping
+// (SendReliable failing) and rejoining (a second handleNotifyJoin) without
+// a real gossip transport.
+type mockMemberList struct {
+	mu       sync.Mutex
+	members  []Node
+	dropping bool
+	sent     []string
+}
+
+func (m *mockMemberList) Members() []Node { return m.members }
+
+func (m *mockMemberList) Join(hosts []string) error { return nil }
+
+func (m *mockMemberList) Shutdown() error { return nil }
+
+func (m *mockMemberList) SendReliable(node string, msg []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.dropping {
+		return errors.New("mock: node unreachable")
+	}
+	m.sent = append(m.sent, node)
+	return nil
+}
+
+func (m *mockMemberList) sentTo(node string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for _, s := range m.sent {
+		if s == node {
+			n++
+		}
+	}
+	return n
+}
+
+func newTestCluster(ml memberList) *Cluster {
+	return &Cluster{
+		cfg:        &Config{Name: "local"},
+		memberList: ml,
+		members:    make(map[string]*Node),
+		leftNodes:  make(map[string]bool),
+		runQueue:   runqueue.New("cluster-test"),
+		queues:     make(map[string]*outboundQueue),
+		audit:      NewDiscardAuditEmitter(),
+	}
+}
+
+func TestCluster_ReplayToRejoinedNode(t *testing.T) {
+	ml := &mockMemberList{}
+	c := newTestCluster(ml)
+	ctx := context.Background()
+
+	c.handleNotifyJoin(ctx, &Node{Name: "peer-1"})
+
+	if err := c.send(ctx, &Message{Type: MessageTypeStanza, Payload: []byte("hi")}, "peer-1"); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if got := ml.sentTo("peer-1"); got != 1 {
+		t.Fatalf("expected 1 send before drop, got %d", got)
+	}
+
+	// peer-1 drops before acking, then a second message is attempted and
+	// fails to go out over the (now unreachable) transport.
+	ml.mu.Lock()
+	ml.dropping = true
+	ml.mu.Unlock()
+	c.handleNotifyLeave(ctx, &Node{Name: "peer-1"})
+
+	if err := c.send(ctx, &Message{Type: MessageTypeStanza, Payload: []byte("bye")}, "peer-1"); err == nil {
+		t.Fatal("expected send to fail while peer-1 is dropped")
+	}
+
+	// peer-1 rejoins: both unacked messages should be replayed.
+	ml.mu.Lock()
+	ml.dropping = false
+	ml.sent = nil
+	ml.mu.Unlock()
+	c.handleNotifyJoin(ctx, &Node{Name: "peer-1"})
+
+	if got := ml.sentTo("peer-1"); got != 2 {
+		t.Fatalf("expected both unacked messages replayed on rejoin, got %d", got)
+	}
+}
+
+func TestCluster_RejoinWithoutPriorLeaveDoesNotReplay(t *testing.T) {
+	ml := &mockMemberList{}
+	c := newTestCluster(ml)
+	ctx := context.Background()
+
+	// A plain join notification (no prior leave) must not trigger a replay.
+	c.handleNotifyJoin(ctx, &Node{Name: "peer-1"})
+	if err := c.send(ctx, &Message{Type: MessageTypeStanza, Payload: []byte("hi")}, "peer-1"); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	ml.mu.Lock()
+	ml.sent = nil
+	ml.mu.Unlock()
+
+	c.handleNotifyJoin(ctx, &Node{Name: "peer-1"})
+	if got := ml.sentTo("peer-1"); got != 0 {
+		t.Fatalf("expected no replay without a preceding leave, got %d", got)
+	}
+}
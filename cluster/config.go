@@ -0,0 +1,63 @@
+/*
+ * Copyright (c) 2018 Miguel Ángel Ortuño.
+ * See the LICENSE file for more information.
+ */
+
+package cluster
+
+import "time"
+
+// ConsistencyMode selects the transport backing Cluster.Propose/Get.
+type ConsistencyMode string
+
+const (
+	// ConsistencyModeGossip runs the cluster on the memberlist gossip
+	// transport only: Cluster.Propose and Cluster.Get return
+	// ErrConsistencyModeNotEnabled in this mode. This is the default.
+	ConsistencyModeGossip ConsistencyMode = "gossip"
+
+	// ConsistencyModeRaft layers a Raft-replicated, linearizable
+	// key/value store on top of gossip for state that must not diverge
+	// (e.g. roster versions, MAM archive indexes, PEP node state).
+	ConsistencyModeRaft ConsistencyMode = "raft"
+)
+
+// Config represents cluster sub system configuration.
+type Config struct {
+	Name      string
+	BindPort  int
+	Hosts     []string
+	InTimeout time.Duration
+
+	// MaxQueueDepth bounds how many unacked messages are buffered per
+	// peer before a full-state resync is requested. Defaults to 4096
+	// when left unset.
+	MaxQueueDepth int
+
+	// ConsistencyMode picks between plain gossip (default) and an
+	// opt-in Raft-backed linearizable mode. Empty defaults to gossip.
+	ConsistencyMode ConsistencyMode
+
+	// Raft configures the Raft transport. Only read when ConsistencyMode
+	// is ConsistencyModeRaft.
+	Raft RaftConfig
+
+	// AuditEmitter receives every cluster node and message event. A nil
+	// value is replaced by a no-op discard emitter, so Cluster is safe
+	// to construct without one.
+	AuditEmitter AuditEmitter
+}
+
+// RaftConfig groups the settings needed to stand up the Raft transport.
+type RaftConfig struct {
+	// BindAddr is the address the Raft transport listens on.
+	BindAddr string
+
+	// DataDir is where the Raft log, stable store and snapshots are
+	// persisted, alongside the rest of the storage configuration.
+	DataDir string
+
+	// Bootstrap, when true, bootstraps a brand new single-node cluster.
+	// It must only be set on the very first node of a fresh deployment.
+	Bootstrap bool
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2018 Miguel Ángel Ortuño.
+ * See the LICENSE file for more information.
+ */
+
+package cluster
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// maxPayloadLen bounds the payload length read off the wire, so a
+// corrupted or malicious length prefix can't trigger a multi-gigabyte
+// allocation before the data backing it has even been read.
+const maxPayloadLen = 64 * 1024 * 1024
+
+// MessageType represents a cluster message type.
+type MessageType uint8
+
+const (
+	// MessageTypeStanza identifies a routed XMPP stanza message.
+	MessageTypeStanza MessageType = iota
+
+	// MessageTypeC2SStream identifies a cluster c2s stream event message.
+	MessageTypeC2SStream
+
+	// MessageTypeAck identifies a cumulative acknowledgement of the
+	// highest contiguous sequence number received from a peer.
+	MessageTypeAck
+
+	// MessageTypeProposal identifies a state-machine command submitted
+	// through Cluster.Propose and replicated via the Raft consistency
+	// mode. It never travels over the gossip transport.
+	MessageTypeProposal
+)
+
+// Message represents a cluster inter-node message.
+type Message struct {
+	Type    MessageType
+	Node    string
+	Seq     uint64
+	Payload []byte
+}
+
+// ToBytes serializes the message into buf.
+func (m *Message) ToBytes(buf *bytes.Buffer) error {
+	buf.WriteByte(byte(m.Type))
+
+	if err := writeString(buf, m.Node); err != nil {
+		return err
+	}
+	if err := binary.Write(buf, binary.BigEndian, m.Seq); err != nil {
+		return err
+	}
+	var ln [4]byte
+	binary.BigEndian.PutUint32(ln[:], uint32(len(m.Payload)))
+	buf.Write(ln[:])
+	buf.Write(m.Payload)
+	return nil
+}
+
+// FromBytes deserializes a message from buf.
+func (m *Message) FromBytes(buf *bytes.Buffer) error {
+	typ, err := buf.ReadByte()
+	if err != nil {
+		return err
+	}
+	m.Type = MessageType(typ)
+
+	node, err := readString(buf)
+	if err != nil {
+		return err
+	}
+	m.Node = node
+
+	if err := binary.Read(buf, binary.BigEndian, &m.Seq); err != nil {
+		return err
+	}
+	var ln [4]byte
+	if _, err := io.ReadFull(buf, ln[:]); err != nil {
+		return err
+	}
+	payloadLen := binary.BigEndian.Uint32(ln[:])
+	if payloadLen > maxPayloadLen {
+		return errors.New("cluster: payload length exceeds maximum")
+	}
+	payload := make([]byte, payloadLen)
+	if _, err := io.ReadFull(buf, payload); err != nil {
+		return err
+	}
+	m.Payload = payload
+	return nil
+}
+
+func writeString(buf *bytes.Buffer, s string) error {
+	if len(s) > 0xFFFF {
+		return errors.New("cluster: string too long")
+	}
+	var ln [2]byte
+	binary.BigEndian.PutUint16(ln[:], uint16(len(s)))
+	buf.Write(ln[:])
+	buf.WriteString(s)
+	return nil
+}
+
+func readString(buf *bytes.Buffer) (string, error) {
+	var ln [2]byte
+	if _, err := io.ReadFull(buf, ln[:]); err != nil {
+		return "", err
+	}
+	strLen := binary.BigEndian.Uint16(ln[:])
+	b := make([]byte, strLen)
+	if _, err := io.ReadFull(buf, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) 2018 Miguel Ángel Ortuño.
+ * See the LICENSE file for more information.
+ */
+
+package cluster
+
+import "sync"
+
+// defaultMaxQueueDepth is used when Config.MaxQueueDepth is left unset.
+const defaultMaxQueueDepth = 4096
+
+// outboundQueue keeps the unacknowledged messages sent to a single peer, in
+// seq order, so they can be replayed if the peer drops and rejoins before
+// acking them.
+type outboundQueue struct {
+	mu        sync.Mutex
+	maxDepth  int
+	lastAcked uint64
+	unacked   []*Message
+}
+
+func newOutboundQueue(maxDepth int) *outboundQueue {
+	if maxDepth <= 0 {
+		maxDepth = defaultMaxQueueDepth
+	}
+	return &outboundQueue{maxDepth: maxDepth}
+}
+
+// push appends msg to the queue, returning false when the queue has grown
+// past its configured max depth (the caller should trigger a full resync).
+// Once that happens, the buffered backlog is dropped rather than left to
+// grow without bound: a full resync makes seq-based replay of it moot, and
+// Config.MaxQueueDepth is meant to actually bound memory per peer.
+func (q *outboundQueue) push(msg *Message) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.unacked = append(q.unacked, msg)
+	if len(q.unacked) > q.maxDepth {
+		q.unacked = nil
+		return false
+	}
+	return true
+}
+
+// ack drops every buffered message with a seq <= upToSeq.
+func (q *outboundQueue) ack(upToSeq uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if upToSeq <= q.lastAcked {
+		return
+	}
+	q.lastAcked = upToSeq
+
+	i := 0
+	for ; i < len(q.unacked); i++ {
+		if q.unacked[i].Seq > upToSeq {
+			break
+		}
+	}
+	q.unacked = q.unacked[i:]
+}
+
+// replay returns every buffered message with a seq > afterSeq, in order.
+func (q *outboundQueue) replay(afterSeq uint64) []*Message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var out []*Message
+	for _, m := range q.unacked {
+		if m.Seq > afterSeq {
+			out = append(out, m)
+		}
+	}
+	return out
+}
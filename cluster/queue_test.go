@@ -0,0 +1,72 @@
+/*
+ * Copyright (c) 2018 Miguel Ángel Ortuño.
+ * See the LICENSE file for more information.
+ */
+
+package cluster
+
+import "testing"
+
+func TestOutboundQueue_PushAckReplay(t *testing.T) {
+	q := newOutboundQueue(0)
+
+	for seq := uint64(1); seq <= 3; seq++ {
+		if !q.push(&Message{Seq: seq}) {
+			t.Fatalf("push(%d) unexpectedly reported overflow", seq)
+		}
+	}
+	if got := q.replay(0); len(got) != 3 {
+		t.Fatalf("expected 3 unacked messages, got %d", len(got))
+	}
+
+	q.ack(2)
+	got := q.replay(0)
+	if len(got) != 1 || got[0].Seq != 3 {
+		t.Fatalf("expected only seq 3 to remain unacked, got %v", got)
+	}
+
+	// acking an already-acked (or stale) seq is a no-op.
+	q.ack(1)
+	if got := q.replay(0); len(got) != 1 {
+		t.Fatalf("stale ack should not resurrect dropped entries, got %v", got)
+	}
+}
+
+func TestOutboundQueue_MaxDepth(t *testing.T) {
+	q := newOutboundQueue(2)
+
+	if !q.push(&Message{Seq: 1}) {
+		t.Fatal("push within max depth reported overflow")
+	}
+	if !q.push(&Message{Seq: 2}) {
+		t.Fatal("push at max depth reported overflow")
+	}
+	if q.push(&Message{Seq: 3}) {
+		t.Fatal("push beyond max depth should report overflow")
+	}
+}
+
+func TestOutboundQueue_MaxDepthBoundsMemory(t *testing.T) {
+	q := newOutboundQueue(2)
+
+	for seq := uint64(1); seq <= 100; seq++ {
+		q.push(&Message{Seq: seq})
+		if len(q.unacked) > 2 {
+			t.Fatalf("unacked grew past max depth: len=%d after seq %d", len(q.unacked), seq)
+		}
+	}
+	if got := q.replay(0); len(got) != 0 {
+		t.Fatalf("expected the stale backlog to be dropped once max depth is exceeded, got %v", got)
+	}
+}
+
+func TestOutboundQueue_ReplayAfterSeq(t *testing.T) {
+	q := newOutboundQueue(0)
+	for seq := uint64(1); seq <= 5; seq++ {
+		q.push(&Message{Seq: seq})
+	}
+	got := q.replay(3)
+	if len(got) != 2 || got[0].Seq != 4 || got[1].Seq != 5 {
+		t.Fatalf("expected seqs [4 5], got %v", got)
+	}
+}
@@ -0,0 +1,230 @@
+/*
+ * Copyright (c) 2018 Miguel Ángel Ortuño.
+ * See the LICENSE file for more information.
+ */
+
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+	"github.com/ortuman/jackal/log"
+)
+
+// ErrConsistencyModeNotEnabled is returned by Cluster.Propose and
+// Cluster.Get when the cluster wasn't configured with
+// Config.ConsistencyMode = ConsistencyModeRaft.
+var ErrConsistencyModeNotEnabled = errors.New("cluster: raft consistency mode is not enabled")
+
+const raftApplyTimeout = 10 * time.Second
+
+// raftNode wraps the Raft transport and its finite state machine, exposing
+// the linearizable key/value operations consumed by Cluster.
+type raftNode struct {
+	raft *raft.Raft
+	fsm  *fsm
+}
+
+func newRaftNode(localName string, cfg *RaftConfig, delegate Delegate) (*raftNode, error) {
+	if err := os.MkdirAll(cfg.DataDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	store := newFSM()
+
+	raftCfg := raft.DefaultConfig()
+	raftCfg.LocalID = raft.ServerID(localName)
+
+	addr, err := net.ResolveTCPAddr("tcp", cfg.BindAddr)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := raft.NewTCPTransport(cfg.BindAddr, addr, 3, 10*time.Second, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	logStorePath := filepath.Join(cfg.DataDir, "raft-log.bolt")
+	boltStore, err := raftboltdb.NewBoltStore(logStorePath)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshotStore, err := raft.NewFileSnapshotStore(cfg.DataDir, 2, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := raft.NewRaft(raftCfg, store, boltStore, boltStore, snapshotStore, transport)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Bootstrap {
+		cfgFuture := r.BootstrapCluster(raft.Configuration{
+			Servers: []raft.Server{{ID: raftCfg.LocalID, Address: transport.LocalAddr()}},
+		})
+		if err := cfgFuture.Error(); err != nil && err != raft.ErrCantBootstrap {
+			return nil, err
+		}
+	}
+
+	rn := &raftNode{raft: r, fsm: store}
+	rn.watchLeadership(delegate)
+	return rn, nil
+}
+
+// watchLeadership forwards Raft leadership-change notifications to the
+// Delegate, as Delegate.LeaderChanged / Delegate.BecameLeader.
+func (rn *raftNode) watchLeadership(delegate Delegate) {
+	if delegate == nil {
+		return
+	}
+	go func() {
+		for becameLeader := range rn.raft.LeaderCh() {
+			delegate.LeaderChanged(string(rn.raft.Leader()))
+			if becameLeader {
+				delegate.BecameLeader(context.Background())
+			}
+		}
+	}()
+}
+
+func (rn *raftNode) propose(_ context.Context, key string, value []byte) error {
+	cmd, err := encodeCommand(key, value)
+	if err != nil {
+		return err
+	}
+	msg := &Message{Type: MessageTypeProposal, Payload: cmd}
+	var buf bytes.Buffer
+	if err := msg.ToBytes(&buf); err != nil {
+		return err
+	}
+	future := rn.raft.Apply(buf.Bytes(), raftApplyTimeout)
+	return future.Error()
+}
+
+// get performs a linearizable read of key. It first verifies, via a Raft
+// quorum round-trip, that the local node is still the leader before
+// reading the local fsm, so a stale leader cut off by a network
+// partition returns an error instead of silently stale data.
+func (rn *raftNode) get(key string) ([]byte, error) {
+	if err := rn.raft.VerifyLeader().Error(); err != nil {
+		return nil, err
+	}
+	return rn.fsm.get(key), nil
+}
+
+func (rn *raftNode) shutdown() error {
+	return rn.raft.Shutdown().Error()
+}
+
+// fsm applies committed MessageTypeProposal commands onto an in-memory
+// key/value map. It's intentionally simple: proposals carry small,
+// infrequent, critical pieces of shared state (roster versions, MAM
+// archive indexes, PEP node state), not the bulk of cluster traffic, which
+// keeps travelling over gossip.
+type fsm struct {
+	mu   sync.RWMutex
+	data map[string][]byte
+}
+
+func newFSM() *fsm {
+	return &fsm{data: make(map[string][]byte)}
+}
+
+func (f *fsm) get(key string) []byte {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.data[key]
+}
+
+// Apply implements raft.FSM.
+func (f *fsm) Apply(l *raft.Log) interface{} {
+	var m Message
+	if err := m.FromBytes(bytes.NewBuffer(l.Data)); err != nil {
+		log.Error(err)
+		return err
+	}
+	key, value, err := decodeCommand(m.Payload)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	f.mu.Lock()
+	f.data[key] = value
+	f.mu.Unlock()
+	return nil
+}
+
+// Snapshot implements raft.FSM.
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	snap := make(map[string][]byte, len(f.data))
+	for k, v := range f.data {
+		snap[k] = v
+	}
+	return &fsmSnapshot{data: snap}, nil
+}
+
+// Restore implements raft.FSM.
+func (f *fsm) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	var data map[string][]byte
+	if err := gob.NewDecoder(rc).Decode(&data); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.data = data
+	f.mu.Unlock()
+	return nil
+}
+
+type fsmSnapshot struct {
+	data map[string][]byte
+}
+
+func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	if err := gob.NewEncoder(sink).Encode(s.data); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *fsmSnapshot) Release() {}
+
+type raftCommand struct {
+	Key   string
+	Value []byte
+}
+
+func encodeCommand(key string, value []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(raftCommand{Key: key, Value: value}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeCommand(data []byte) (key string, value []byte, err error) {
+	var cmd raftCommand
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&cmd); err != nil {
+		return "", nil, err
+	}
+	return cmd.Key, cmd.Value, nil
+}
@@ -0,0 +1,200 @@
+/*
+ * Copyright (c) 2018 Miguel Ángel Ortuño.
+ * See the LICENSE file for more information.
+ */
+
+package xep0054
+
+import (
+	"strings"
+
+	"github.com/ortuman/jackal/xmpp"
+)
+
+// vCard4Namespace is the namespace used by XEP-0292 vCard4 over XMPP.
+const vCard4Namespace = "urn:ietf:params:xml:ns:vcard-4.0"
+
+// canonical is the internal, format-agnostic representation a vCard is
+// stored under, so legacy vcard-temp (XEP-0054) and vCard4 (XEP-0292)
+// clients transparently read and write the same record.
+type canonical struct {
+	FN       string
+	N        string
+	Nickname string
+	Email    string
+	Tel      string
+	Adr      string
+	Org      string
+	URL      string
+	Note     string
+	Impp     string
+
+	PhotoType   string
+	PhotoBinVal string
+
+	// sidecar preserves any child element this server doesn't understand
+	// yet, keyed by element name, so it survives a read-modify-write round
+	// trip instead of being silently dropped. It's stored independently of
+	// which format (legacy vcard-temp or vCard4) it was read from: the
+	// record always persists through toLegacy, so an unknown vCard4 child
+	// must still make it into the persisted legacy element to survive at
+	// all, and is then re-emitted in whichever format is requested.
+	sidecar map[string]xmpp.XElement
+}
+
+var canonicalSingleFields = []struct {
+	legacyName, vCard4Name string
+	get                    func(*canonical) *string
+}{
+	{"FN", "fn", func(c *canonical) *string { return &c.FN }},
+	{"N", "n", func(c *canonical) *string { return &c.N }},
+	{"NICKNAME", "nickname", func(c *canonical) *string { return &c.Nickname }},
+	{"EMAIL", "email", func(c *canonical) *string { return &c.Email }},
+	{"TEL", "tel", func(c *canonical) *string { return &c.Tel }},
+	{"ADR", "adr", func(c *canonical) *string { return &c.Adr }},
+	{"ORG", "org", func(c *canonical) *string { return &c.Org }},
+	{"URL", "url", func(c *canonical) *string { return &c.URL }},
+	{"NOTE", "note", func(c *canonical) *string { return &c.Note }},
+	{"IMPP", "impp", func(c *canonical) *string { return &c.Impp }},
+}
+
+// parseLegacy builds a canonical record out of a vcard-temp <vCard/> element.
+func parseLegacy(el xmpp.XElement) canonical {
+	var c canonical
+	known := make(map[string]bool, len(canonicalSingleFields)+1)
+	for _, f := range canonicalSingleFields {
+		known[f.legacyName] = true
+		if child := el.Elements().Child(f.legacyName); child != nil {
+			*f.get(&c) = child.Text()
+		}
+	}
+	known["PHOTO"] = true
+	if photo := el.Elements().Child("PHOTO"); photo != nil {
+		if t := photo.Elements().Child("TYPE"); t != nil {
+			c.PhotoType = t.Text()
+		}
+		if b := photo.Elements().Child("BINVAL"); b != nil {
+			c.PhotoBinVal = b.Text()
+		}
+	}
+	c.sidecar = stashUnknown(el, known)
+	return c
+}
+
+// toLegacy serializes c as a vcard-temp <vCard/> element.
+func toLegacy(c canonical) xmpp.XElement {
+	v := xmpp.NewElementNamespace("vCard", vCardNamespace)
+	for _, f := range canonicalSingleFields {
+		if val := *f.get(&c); len(val) > 0 {
+			el := xmpp.NewElementName(f.legacyName)
+			el.SetText(val)
+			v.AppendElement(el)
+		}
+	}
+	if len(c.PhotoBinVal) > 0 || len(c.PhotoType) > 0 {
+		photo := xmpp.NewElementName("PHOTO")
+		if len(c.PhotoType) > 0 {
+			t := xmpp.NewElementName("TYPE")
+			t.SetText(c.PhotoType)
+			photo.AppendElement(t)
+		}
+		b := xmpp.NewElementName("BINVAL")
+		b.SetText(c.PhotoBinVal)
+		photo.AppendElement(b)
+		v.AppendElement(photo)
+	}
+	appendSidecar(v, c.sidecar)
+	return v
+}
+
+// parseVCard4 builds a canonical record out of a vCard4 <vcard/> element.
+func parseVCard4(el xmpp.XElement) canonical {
+	var c canonical
+	known := make(map[string]bool, len(canonicalSingleFields)+1)
+	for _, f := range canonicalSingleFields {
+		known[f.vCard4Name] = true
+		if child := el.Elements().Child(f.vCard4Name); child != nil {
+			if text := child.Elements().Child("text"); text != nil {
+				*f.get(&c) = text.Text()
+			}
+		}
+	}
+	known["photo"] = true
+	if photo := el.Elements().Child("photo"); photo != nil {
+		if uri := photo.Elements().Child("uri"); uri != nil {
+			c.PhotoType, c.PhotoBinVal = splitDataURI(uri.Text())
+		}
+	}
+	c.sidecar = stashUnknown(el, known)
+	return c
+}
+
+// toVCard4 serializes c as a vCard4 <vcard/> element.
+func toVCard4(c canonical) xmpp.XElement {
+	v := xmpp.NewElementNamespace("vcard", vCard4Namespace)
+	for _, f := range canonicalSingleFields {
+		if val := *f.get(&c); len(val) > 0 {
+			el := xmpp.NewElementName(f.vCard4Name)
+			text := xmpp.NewElementName("text")
+			text.SetText(val)
+			el.AppendElement(text)
+			v.AppendElement(el)
+		}
+	}
+	if len(c.PhotoBinVal) > 0 {
+		photo := xmpp.NewElementName("photo")
+		uri := xmpp.NewElementName("uri")
+		uri.SetText(joinDataURI(c.PhotoType, c.PhotoBinVal))
+		photo.AppendElement(uri)
+		v.AppendElement(photo)
+	}
+	appendSidecar(v, c.sidecar)
+	return v
+}
+
+// stashUnknown preserves every child of el not listed in known, so a future
+// read-modify-write round trip — through the same format or a different
+// one — doesn't drop it.
+func stashUnknown(el xmpp.XElement, known map[string]bool) map[string]xmpp.XElement {
+	var sidecar map[string]xmpp.XElement
+	for _, child := range el.Elements().All() {
+		if known[child.Name()] {
+			continue
+		}
+		if sidecar == nil {
+			sidecar = make(map[string]xmpp.XElement)
+		}
+		sidecar[child.Name()] = child
+	}
+	return sidecar
+}
+
+func appendSidecar(el xmpp.XElement, sidecar map[string]xmpp.XElement) {
+	for _, child := range sidecar {
+		el.AppendElement(child)
+	}
+}
+
+// joinDataURI packs a MIME type and base64 payload into the data: URI form
+// vCard4's PHOTO;VALUE=uri expects.
+func joinDataURI(mimeType, base64Val string) string {
+	if len(mimeType) == 0 {
+		mimeType = "image/jpeg"
+	}
+	return "data:" + mimeType + ";base64," + base64Val
+}
+
+// splitDataURI is the inverse of joinDataURI; it tolerates a bare base64
+// payload (no data: prefix) by returning it as-is with an empty MIME type.
+func splitDataURI(uri string) (mimeType, base64Val string) {
+	const prefix = "data:"
+	if !strings.HasPrefix(uri, prefix) {
+		return "", uri
+	}
+	rest := uri[len(prefix):]
+	i := strings.Index(rest, ";base64,")
+	if i < 0 {
+		return "", uri
+	}
+	return rest[:i], rest[i+len(";base64,"):]
+}
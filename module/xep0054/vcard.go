@@ -0,0 +1,171 @@
+/*
+ * Copyright (c) 2018 Miguel Ángel Ortuño.
+ * See the LICENSE file for more information.
+ */
+
+package xep0054
+
+import (
+	"context"
+
+	"github.com/ortuman/jackal/log"
+	"github.com/ortuman/jackal/module/xep0030"
+	"github.com/ortuman/jackal/router"
+	"github.com/ortuman/jackal/runqueue"
+	"github.com/ortuman/jackal/storage"
+	"github.com/ortuman/jackal/stream"
+	"github.com/ortuman/jackal/xmpp"
+	"github.com/ortuman/jackal/xmpp/jid"
+)
+
+const vCardNamespace = "vcard-temp"
+
+// AvatarPublisher is implemented by modules that need to react whenever a
+// user's vCard photo changes, so they can republish it through other
+// avatar-related protocols (e.g. XEP-0153, XEP-0084).
+type AvatarPublisher interface {
+	// PublishAvatar is invoked right after a vCard has been stored,
+	// with the base64 encoded contents of its <PHOTO><BINVAL> element
+	// (empty when the vCard carries no photo).
+	PublishAvatar(ctx context.Context, userJID *jid.JID, photoB64 string)
+}
+
+// VCard represents a vCard-temp (XEP-0054) server stream module.
+type VCard struct {
+	router    router.Router
+	publisher AvatarPublisher
+	runQueue  *runqueue.RunQueue
+}
+
+// New returns a new vCard-temp IQ handler.
+func New(disco *xep0030.DiscoInfo, router router.Router, avatarPublisher AvatarPublisher) *VCard {
+	v := &VCard{
+		router:    router,
+		publisher: avatarPublisher,
+		runQueue:  runqueue.New("xep0054"),
+	}
+	if disco != nil {
+		disco.RegisterServerFeature(vCardNamespace)
+		disco.RegisterAccountFeature(vCardNamespace)
+	}
+	return v
+}
+
+// MatchesIQ returns whether or not an IQ should be processed by this module.
+// Both legacy vcard-temp (XEP-0054) and vCard4 (XEP-0292) requests match.
+func (x *VCard) MatchesIQ(iq *xmpp.IQ) bool {
+	return iq.Elements().ChildNamespace("vCard", vCardNamespace) != nil ||
+		iq.Elements().ChildNamespace("vcard", vCard4Namespace) != nil
+}
+
+// ProcessIQ processes a vCard IQ taking according actions over the associated stream.
+func (x *VCard) ProcessIQ(iq *xmpp.IQ, stm stream.C2S) {
+	x.runQueue.Run(func() {
+		x.processIQ(context.Background(), iq, stm)
+	})
+}
+
+func (x *VCard) processIQ(ctx context.Context, iq *xmpp.IQ, stm stream.C2S) {
+	ns := vCardNamespace
+	el := iq.Elements().ChildNamespace("vCard", vCardNamespace)
+	if el == nil {
+		ns = vCard4Namespace
+		el = iq.Elements().ChildNamespace("vcard", vCard4Namespace)
+	}
+	switch {
+	case iq.IsGet():
+		x.getVCard(el, ns, iq, stm)
+	case iq.IsSet():
+		x.setVCard(ctx, el, ns, iq, stm)
+	default:
+		stm.SendElement(iq.BadRequestError())
+	}
+}
+
+func (x *VCard) getVCard(el xmpp.XElement, ns string, iq *xmpp.IQ, stm stream.C2S) {
+	if el.Elements().Count() > 0 {
+		stm.SendElement(iq.BadRequestError())
+		return
+	}
+	c, err := x.fetchCanonical(iq.ToJID())
+	if err != nil {
+		log.Error(err)
+		stm.SendElement(iq.InternalServerError())
+		return
+	}
+	resultIQ := iq.ResultIQ()
+	if ns == vCard4Namespace {
+		resultIQ.AppendElement(toVCard4(c))
+	} else {
+		resultIQ.AppendElement(toLegacy(c))
+	}
+	stm.SendElement(resultIQ)
+}
+
+func (x *VCard) setVCard(ctx context.Context, el xmpp.XElement, ns string, iq *xmpp.IQ, stm stream.C2S) {
+	fromJID := iq.FromJID()
+	toJID := iq.ToJID()
+	if toJID.Node() != fromJID.Node() {
+		stm.SendElement(iq.ForbiddenError())
+		return
+	}
+	var c canonical
+	if ns == vCard4Namespace {
+		c = parseVCard4(el)
+	} else {
+		c = parseLegacy(el)
+	}
+	if err := storage.InsertOrUpdateVCard(toLegacy(c), toJID.Node()); err != nil {
+		log.Error(err)
+		stm.SendElement(iq.InternalServerError())
+		return
+	}
+	stm.SendElement(iq.ResultIQ())
+
+	if x.publisher != nil {
+		x.publisher.PublishAvatar(ctx, toJID, c.PhotoBinVal)
+	}
+}
+
+// fetchCanonical returns the canonical vCard record for userJID, or a zero
+// value record when the user has never set one. The record is persisted in
+// its legacy vcard-temp shape — a superset of both supported formats — so
+// it transparently serves vcard-temp and vCard4 requests alike.
+func (x *VCard) fetchCanonical(userJID *jid.JID) (canonical, error) {
+	stored, err := storage.FetchVCard(userJID.Node())
+	if err != nil {
+		return canonical{}, err
+	}
+	if stored == nil {
+		return canonical{}, nil
+	}
+	return parseLegacy(stored), nil
+}
+
+// Photo returns the base64 encoded photo and its declared MIME type
+// currently stored in userJID's vCard. It's meant for modules that learn
+// about an avatar change only as a hash (e.g. the XEP-0084 PEP module,
+// notified through xep0153.Pep) and need to read back the actual photo
+// bytes.
+func (x *VCard) Photo(userJID *jid.JID) (photoB64, mimeType string, err error) {
+	c, err := x.fetchCanonical(userJID)
+	if err != nil {
+		return "", "", err
+	}
+	return c.PhotoBinVal, c.PhotoType, nil
+}
+
+// UpdatePhoto merges a new photo into userJID's vCard, preserving every
+// other field via a read-merge-write. It's meant for modules synchronizing
+// an alternate avatar source (e.g. the XEP-0084 PEP module) back into
+// vcard-temp, and deliberately skips the AvatarPublisher notification to
+// avoid a publish feedback loop between the two protocols.
+func (x *VCard) UpdatePhoto(userJID *jid.JID, photoB64, mimeType string) error {
+	c, err := x.fetchCanonical(userJID)
+	if err != nil {
+		return err
+	}
+	c.PhotoType = mimeType
+	c.PhotoBinVal = photoB64
+	return storage.InsertOrUpdateVCard(toLegacy(c), userJID.Node())
+}
@@ -19,7 +19,7 @@ import (
 func TestXEP0054_Matching(t *testing.T) {
 	j, _ := jid.New("ortuman", "jackal.im", "balcony", true)
 
-	x := New(nil, nil)
+	x := New(nil, nil, nil)
 
 	// test MatchesIQ
 	iqID := uuid.New()
@@ -54,7 +54,7 @@ func TestXEP0054_Set(t *testing.T) {
 	iq.SetToJID(j.ToBareJID())
 	iq.AppendElement(testVCard())
 
-	x := New(nil, nil)
+	x := New(nil, nil, nil)
 
 	x.ProcessIQ(iq, stm)
 	elem := stm.FetchElement()
@@ -86,7 +86,7 @@ func TestXEP0054_SetError(t *testing.T) {
 	stm := stream.NewMockC2S("abcd", j)
 	defer stm.Disconnect(nil)
 
-	x := New(nil, nil)
+	x := New(nil, nil, nil)
 
 	// set other user vCard...
 	iq := xmpp.NewIQType(uuid.New(), xmpp.SetType)
@@ -127,7 +127,7 @@ func TestXEP0054_Get(t *testing.T) {
 	iqSet.SetToJID(j.ToBareJID())
 	iqSet.AppendElement(testVCard())
 
-	x := New(nil, nil)
+	x := New(nil, nil, nil)
 
 	x.ProcessIQ(iqSet, stm)
 	_ = stm.FetchElement() // wait until set...
@@ -173,7 +173,7 @@ func TestXEP0054_GetError(t *testing.T) {
 	iqSet.SetToJID(j.ToBareJID())
 	iqSet.AppendElement(testVCard())
 
-	x := New(nil, nil)
+	x := New(nil, nil, nil)
 
 	x.ProcessIQ(iqSet, stm)
 	_ = stm.FetchElement() // wait until set...
@@ -204,6 +204,143 @@ func TestXEP0054_GetError(t *testing.T) {
 	require.Equal(t, xmpp.ErrInternalServerError.Error(), elem.Error().Elements().All()[0].Name())
 }
 
+func TestXEP0054_SetGet_VCard4(t *testing.T) {
+	storage.Initialize(&storage.Config{Type: storage.Memory})
+	defer storage.Shutdown()
+
+	j, _ := jid.New("ortuman", "jackal.im", "balcony", true)
+
+	stm := stream.NewMockC2S("abcd", j)
+	defer stm.Disconnect(nil)
+
+	x := New(nil, nil, nil)
+
+	iqSet := xmpp.NewIQType(uuid.New(), xmpp.SetType)
+	iqSet.SetFromJID(j)
+	iqSet.SetToJID(j.ToBareJID())
+	iqSet.AppendElement(testVCard4())
+
+	x.ProcessIQ(iqSet, stm)
+	elem := stm.FetchElement()
+	require.Equal(t, xmpp.ResultType, elem.Type())
+
+	iqGet := xmpp.NewIQType(uuid.New(), xmpp.GetType)
+	iqGet.SetFromJID(j)
+	iqGet.SetToJID(j.ToBareJID())
+	iqGet.AppendElement(xmpp.NewElementNamespace("vcard", vCard4Namespace))
+
+	x.ProcessIQ(iqGet, stm)
+	elem = stm.FetchElement()
+	vCard4 := elem.Elements().ChildNamespace("vcard", vCard4Namespace)
+	require.NotNil(t, vCard4)
+	fn := vCard4.Elements().Child("fn")
+	require.Equal(t, "Forrest Gump", fn.Elements().Child("text").Text())
+}
+
+func TestXEP0054_CrossFormat(t *testing.T) {
+	storage.Initialize(&storage.Config{Type: storage.Memory})
+	defer storage.Shutdown()
+
+	j, _ := jid.New("ortuman", "jackal.im", "balcony", true)
+
+	stm := stream.NewMockC2S("abcd", j)
+	defer stm.Disconnect(nil)
+
+	x := New(nil, nil, nil)
+
+	// set via legacy vcard-temp...
+	iqSet := xmpp.NewIQType(uuid.New(), xmpp.SetType)
+	iqSet.SetFromJID(j)
+	iqSet.SetToJID(j.ToBareJID())
+	iqSet.AppendElement(testVCard())
+
+	x.ProcessIQ(iqSet, stm)
+	_ = stm.FetchElement()
+
+	// ...and get via vCard4.
+	iqGet := xmpp.NewIQType(uuid.New(), xmpp.GetType)
+	iqGet.SetFromJID(j)
+	iqGet.SetToJID(j.ToBareJID())
+	iqGet.AppendElement(xmpp.NewElementNamespace("vcard", vCard4Namespace))
+
+	x.ProcessIQ(iqGet, stm)
+	elem := stm.FetchElement()
+	vCard4 := elem.Elements().ChildNamespace("vcard", vCard4Namespace)
+	fn := vCard4.Elements().Child("fn")
+	require.Equal(t, "Forrest Gump", fn.Elements().Child("text").Text())
+
+	// set via vCard4...
+	iqSet2 := xmpp.NewIQType(uuid.New(), xmpp.SetType)
+	iqSet2.SetFromJID(j)
+	iqSet2.SetToJID(j.ToBareJID())
+	iqSet2.AppendElement(testVCard4())
+
+	x.ProcessIQ(iqSet2, stm)
+	_ = stm.FetchElement()
+
+	// ...and get via legacy vcard-temp.
+	iqGet2 := xmpp.NewIQType(uuid.New(), xmpp.GetType)
+	iqGet2.SetFromJID(j)
+	iqGet2.SetToJID(j.ToBareJID())
+	iqGet2.AppendElement(xmpp.NewElementNamespace("vCard", vCardNamespace))
+
+	x.ProcessIQ(iqGet2, stm)
+	elem = stm.FetchElement()
+	vCard := elem.Elements().ChildNamespace("vCard", vCardNamespace)
+	require.Equal(t, "Forrest Gump", vCard.Elements().Child("FN").Text())
+}
+
+func TestXEP0054_UnknownElementSurvivesCrossFormatRoundTrip(t *testing.T) {
+	storage.Initialize(&storage.Config{Type: storage.Memory})
+	defer storage.Shutdown()
+
+	j, _ := jid.New("ortuman", "jackal.im", "balcony", true)
+
+	stm := stream.NewMockC2S("abcd", j)
+	defer stm.Disconnect(nil)
+
+	x := New(nil, nil, nil)
+
+	// set via vCard4 with an element this server doesn't understand...
+	vCard4 := testVCard4()
+	unknown := xmpp.NewElementName("x-unknown")
+	unknown.SetText("opaque-payload")
+	vCard4.AppendElement(unknown)
+
+	iqSet := xmpp.NewIQType(uuid.New(), xmpp.SetType)
+	iqSet.SetFromJID(j)
+	iqSet.SetToJID(j.ToBareJID())
+	iqSet.AppendElement(vCard4)
+
+	x.ProcessIQ(iqSet, stm)
+	_ = stm.FetchElement()
+
+	// ...it must survive a get in vCard4...
+	iqGet := xmpp.NewIQType(uuid.New(), xmpp.GetType)
+	iqGet.SetFromJID(j)
+	iqGet.SetToJID(j.ToBareJID())
+	iqGet.AppendElement(xmpp.NewElementNamespace("vcard", vCard4Namespace))
+
+	x.ProcessIQ(iqGet, stm)
+	elem := stm.FetchElement()
+	got := elem.Elements().ChildNamespace("vcard", vCard4Namespace).Elements().Child("x-unknown")
+	require.NotNil(t, got)
+	require.Equal(t, "opaque-payload", got.Text())
+
+	// ...and in legacy vcard-temp, since storage always round-trips
+	// through the legacy shape.
+	iqGet2 := xmpp.NewIQType(uuid.New(), xmpp.GetType)
+	iqGet2.SetFromJID(j)
+	iqGet2.SetToJID(j.ToBareJID())
+	iqGet2.AppendElement(xmpp.NewElementNamespace("vCard", vCardNamespace))
+
+	x.ProcessIQ(iqGet2, stm)
+	elem = stm.FetchElement()
+	got = elem.Elements().ChildNamespace("vCard", vCardNamespace).Elements().Child("x-unknown")
+	require.NotNil(t, got)
+	require.Equal(t, "opaque-payload", got.Text())
+}
+
 func testVCard() xmpp.XElement {
 	vCard := xmpp.NewElementNamespace("vCard", vCardNamespace)
 	fn := xmpp.NewElementName("FN")
@@ -214,3 +351,13 @@ func testVCard() xmpp.XElement {
 	vCard.AppendElement(org)
 	return vCard
 }
+
+func testVCard4() xmpp.XElement {
+	vCard := xmpp.NewElementNamespace("vcard", vCard4Namespace)
+	fn := xmpp.NewElementName("fn")
+	text := xmpp.NewElementName("text")
+	text.SetText("Forrest Gump")
+	fn.AppendElement(text)
+	vCard.AppendElement(fn)
+	return vCard
+}
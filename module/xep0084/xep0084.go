@@ -0,0 +1,167 @@
+/*
+ * Copyright (c) 2018 Miguel Ángel Ortuño.
+ * See the LICENSE file for more information.
+ */
+
+// Package xep0084 implements XEP-0084 (User Avatar): avatar data and
+// metadata are published as PEP items and kept in sync with the legacy
+// vcard-temp (XEP-0054) avatar.
+//
+// The vcard-temp side of that sync flows through xep0153 rather than
+// xep0054.AvatarPublisher directly: xep0054.VCard only has room for a
+// single AvatarPublisher, and xep0153 already occupies it (to stamp the
+// XEP-0153 presence hash), so xep0084 chains off of xep0153 instead of
+// competing for the same slot. vCard-temp -> PEP flows through
+// xep0153.Pep.AvatarHashUpdated; PEP -> vCard-temp flows through
+// xep0054.VCard.UpdatePhoto, followed by xep0153.VCardAvatar.Publish to
+// refresh the presence hash, so the presence hash and stored vCard avatar
+// both stay in sync regardless of which side the update enters from.
+package xep0084
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"strconv"
+
+	"github.com/ortuman/jackal/log"
+	"github.com/ortuman/jackal/module/xep0054"
+	"github.com/ortuman/jackal/module/xep0153"
+	"github.com/ortuman/jackal/module/xep0163"
+	"github.com/ortuman/jackal/storage"
+	"github.com/ortuman/jackal/xmpp"
+	"github.com/ortuman/jackal/xmpp/jid"
+)
+
+const (
+	dataNamespace     = "urn:xmpp:avatar:data"
+	metadataNamespace = "urn:xmpp:avatar:metadata"
+)
+
+// UserAvatar represents a XEP-0084 server stream module. It publishes PEP
+// avatar updates that originate from vcard-temp, and applies PEP avatar
+// updates that originate from a client back onto the vCard.
+type UserAvatar struct {
+	pep         *xep0163.Pep
+	vCard       *xep0054.VCard
+	vCardAvatar *xep0153.VCardAvatar
+}
+
+// New returns a new XEP-0084 module, backed by the given PEP service and
+// wired to vCard and vCardAvatar for bidirectional vcard-temp
+// synchronization. The returned *UserAvatar implements xep0153.Pep, so it
+// should be passed as the xep0153.New pep argument to receive vCard
+// avatar hash updates.
+func New(pep *xep0163.Pep, vCard *xep0054.VCard, vCardAvatar *xep0153.VCardAvatar) *UserAvatar {
+	a := &UserAvatar{pep: pep, vCard: vCard, vCardAvatar: vCardAvatar}
+	pep.RegisterNodeHandler(dataNamespace, a)
+	return a
+}
+
+// AvatarHashUpdated implements xep0153.Pep. It's invoked right after a
+// client sets a vCard with a photo, and republishes the photo as a PEP
+// item. The hash itself isn't needed here: xep0153 already persisted it,
+// so the current photo is re-read from the vCard.
+func (x *UserAvatar) AvatarHashUpdated(ctx context.Context, userJID *jid.JID, hash string) {
+	if len(hash) == 0 {
+		return
+	}
+	photoB64, _, err := x.vCard.Photo(userJID)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if len(photoB64) == 0 {
+		return
+	}
+	raw, err := base64.StdEncoding.DecodeString(photoB64)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if err := x.publish(ctx, userJID, raw); err != nil {
+		log.Error(err)
+	}
+}
+
+// NotifyItemPublished implements xep0163.NodeHandler. It's invoked whenever
+// a client publishes an item to its own urn:xmpp:avatar:data node, and
+// mirrors the new avatar back onto the user's vCard. UpdatePhoto
+// deliberately skips the AvatarPublisher notification to avoid a publish
+// feedback loop, so xep0153's stored hash and presence stamp are refreshed
+// explicitly here instead, through VCardAvatar.Publish.
+func (x *UserAvatar) NotifyItemPublished(ctx context.Context, userJID *jid.JID, item xmpp.XElement) error {
+	data := item.Elements().ChildNamespace("data", dataNamespace)
+	if data == nil {
+		return nil
+	}
+	photoB64 := data.Text()
+	raw, err := base64.StdEncoding.DecodeString(photoB64)
+	if err != nil {
+		return err
+	}
+	mimeType := http.DetectContentType(raw)
+	if err := x.vCard.UpdatePhoto(userJID, photoB64, mimeType); err != nil {
+		return err
+	}
+	if x.vCardAvatar != nil {
+		if err := x.vCardAvatar.Publish(ctx, userJID, photoB64); err != nil {
+			return err
+		}
+	}
+	return x.persist(userJID.Node(), raw)
+}
+
+func (x *UserAvatar) publish(ctx context.Context, userJID *jid.JID, raw []byte) error {
+	mimeType := http.DetectContentType(raw)
+	sum := sha1.Sum(raw)
+	id := hex.EncodeToString(sum[:])
+
+	width, height := dimensions(raw)
+
+	dataEl := xmpp.NewElementNamespace("data", dataNamespace)
+	dataEl.SetText(base64.StdEncoding.EncodeToString(raw))
+
+	metadataEl := xmpp.NewElementNamespace("metadata", metadataNamespace)
+	info := xmpp.NewElementName("info")
+	info.SetAttribute("id", id)
+	info.SetAttribute("bytes", strconv.Itoa(len(raw)))
+	info.SetAttribute("type", mimeType)
+	if width > 0 {
+		info.SetAttribute("width", strconv.Itoa(width))
+	}
+	if height > 0 {
+		info.SetAttribute("height", strconv.Itoa(height))
+	}
+	metadataEl.AppendElement(info)
+
+	if err := x.pep.PublishItem(ctx, userJID, dataNamespace, id, dataEl); err != nil {
+		return err
+	}
+	if err := x.pep.PublishItem(ctx, userJID, metadataNamespace, id, metadataEl); err != nil {
+		return err
+	}
+	return x.persist(userJID.Node(), raw)
+}
+
+// persist stores the raw avatar bytes so PEP items survive a server restart.
+func (x *UserAvatar) persist(node string, raw []byte) error {
+	return storage.InsertOrUpdateAvatarImage(node, raw)
+}
+
+// dimensions sniffs the pixel width and height out of raw, returning 0, 0
+// when the format can't be decoded.
+func dimensions(raw []byte) (width, height int) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return 0, 0
+	}
+	return cfg.Width, cfg.Height
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright (c) 2018 Miguel Ángel Ortuño.
+ * See the LICENSE file for more information.
+ */
+
+package xep0084
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/ortuman/jackal/module/xep0054"
+	"github.com/ortuman/jackal/module/xep0153"
+	"github.com/ortuman/jackal/storage"
+	"github.com/ortuman/jackal/xmpp"
+	"github.com/ortuman/jackal/xmpp/jid"
+	"github.com/stretchr/testify/require"
+)
+
+// oneByOneGIF is a minimal valid 1x1 single-pixel GIF, used to exercise
+// dimensions() without depending on a real avatar image.
+const oneByOneGIF = "R0lGODlhAQABAIAAAAAAAP///ywAAAAAAQABAAACAkQBADs="
+
+func TestXEP0084_Dimensions(t *testing.T) {
+	raw, err := base64.StdEncoding.DecodeString(oneByOneGIF)
+	require.NoError(t, err)
+
+	width, height := dimensions(raw)
+	require.Equal(t, 1, width)
+	require.Equal(t, 1, height)
+
+	width, height = dimensions([]byte("not an image"))
+	require.Equal(t, 0, width)
+	require.Equal(t, 0, height)
+}
+
+func itemWithData(photoB64 string) xmpp.XElement {
+	item := xmpp.NewElementName("item")
+	data := xmpp.NewElementNamespace("data", dataNamespace)
+	data.SetText(photoB64)
+	item.AppendElement(data)
+	return item
+}
+
+func TestXEP0084_NotifyItemPublished_SyncsVCardAndPresenceHash(t *testing.T) {
+	storage.Initialize(&storage.Config{Type: storage.Memory})
+	defer storage.Shutdown()
+
+	j, _ := jid.New("ortuman", "jackal.im", "balcony", true)
+
+	vCard := xep0054.New(nil, nil, nil)
+	vCardAvatar := xep0153.New(nil, nil)
+	x := &UserAvatar{vCard: vCard, vCardAvatar: vCardAvatar}
+
+	raw, err := base64.StdEncoding.DecodeString(oneByOneGIF)
+	require.NoError(t, err)
+	photoB64 := base64.StdEncoding.EncodeToString(raw)
+
+	require.NoError(t, x.NotifyItemPublished(context.Background(), j, itemWithData(photoB64)))
+
+	gotPhoto, mimeType, err := vCard.Photo(j)
+	require.NoError(t, err)
+	require.Equal(t, photoB64, gotPhoto)
+	require.Equal(t, "image/gif", mimeType)
+
+	// the reverse (PEP -> vcard-temp) sync must also refresh xep0153's
+	// stored hash, so presence stops advertising the stale photo hash.
+	hash, found, err := xep0153.AvatarHash(j)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.NotEmpty(t, hash)
+}
+
+func TestXEP0084_NotifyItemPublished_NoDataElement(t *testing.T) {
+	storage.Initialize(&storage.Config{Type: storage.Memory})
+	defer storage.Shutdown()
+
+	j, _ := jid.New("ortuman", "jackal.im", "balcony", true)
+
+	vCard := xep0054.New(nil, nil, nil)
+	x := &UserAvatar{vCard: vCard}
+
+	item := xmpp.NewElementName("item")
+	require.NoError(t, x.NotifyItemPublished(context.Background(), j, item))
+
+	gotPhoto, _, err := vCard.Photo(j)
+	require.NoError(t, err)
+	require.Equal(t, "", gotPhoto)
+}
+
+func TestXEP0084_AvatarHashUpdated_EmptyHashIsNoop(t *testing.T) {
+	storage.Initialize(&storage.Config{Type: storage.Memory})
+	defer storage.Shutdown()
+
+	j, _ := jid.New("ortuman", "jackal.im", "balcony", true)
+
+	vCard := xep0054.New(nil, nil, nil)
+	x := &UserAvatar{vCard: vCard}
+
+	// must not panic despite x.pep being nil: an empty hash returns
+	// before ever reaching the PEP publish path.
+	x.AvatarHashUpdated(context.Background(), j, "")
+}
+
+func TestXEP0084_AvatarHashUpdated_NoStoredPhotoIsNoop(t *testing.T) {
+	storage.Initialize(&storage.Config{Type: storage.Memory})
+	defer storage.Shutdown()
+
+	j, _ := jid.New("ortuman", "jackal.im", "balcony", true)
+
+	vCard := xep0054.New(nil, nil, nil)
+	x := &UserAvatar{vCard: vCard}
+
+	// hash is non-empty but the vCard has no photo on record: must not
+	// panic despite x.pep being nil, since publish() is never reached.
+	x.AvatarHashUpdated(context.Background(), j, "somehash")
+}
@@ -0,0 +1,166 @@
+/*
+ * Copyright (c) 2018 Miguel Ángel Ortuño.
+ * See the LICENSE file for more information.
+ */
+
+// Package xep0153 implements XEP-0153 (vCard-Based Avatars): it keeps the
+// photo hash advertised in outbound presence stanzas in sync with the photo
+// stored in the user's vCard-temp (XEP-0054).
+package xep0153
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+
+	"github.com/ortuman/jackal/log"
+	"github.com/ortuman/jackal/router"
+	"github.com/ortuman/jackal/runqueue"
+	"github.com/ortuman/jackal/storage"
+	"github.com/ortuman/jackal/xmpp"
+	"github.com/ortuman/jackal/xmpp/jid"
+)
+
+const vCardUpdateNamespace = "vcard-temp:x:update"
+
+// Pep is implemented by modules that want to be notified whenever the
+// XEP-0153 avatar hash for a user changes, so they can keep other
+// avatar-related protocols (e.g. XEP-0084) in sync.
+type Pep interface {
+	AvatarHashUpdated(ctx context.Context, userJID *jid.JID, hash string)
+}
+
+// VCardAvatar represents a XEP-0153 server stream module.
+type VCardAvatar struct {
+	router   router.Router
+	pep      Pep
+	runQueue *runqueue.RunQueue
+}
+
+// New returns a new XEP-0153 module, optionally wired to a Pep delegate
+// (e.g. the XEP-0084 module) kept in sync with the computed avatar hash.
+func New(router router.Router, pep Pep) *VCardAvatar {
+	return &VCardAvatar{
+		router:   router,
+		pep:      pep,
+		runQueue: runqueue.New("xep0153"),
+	}
+}
+
+// PublishAvatar implements xep0054.AvatarPublisher. It's invoked by the
+// vCard-temp module right after a vCard has been stored.
+func (x *VCardAvatar) PublishAvatar(ctx context.Context, userJID *jid.JID, photoB64 string) {
+	x.runQueue.Run(func() {
+		x.publishAvatar(ctx, userJID, photoB64)
+	})
+}
+
+func (x *VCardAvatar) publishAvatar(ctx context.Context, userJID *jid.JID, photoB64 string) {
+	hash, err := hashPhoto(photoB64)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if err := storage.InsertOrUpdateVCardAvatarHash(userJID.Node(), hash); err != nil {
+		log.Error(err)
+		return
+	}
+	x.sendPresenceUpdate(ctx, userJID, hash)
+
+	if x.pep != nil {
+		x.pep.AvatarHashUpdated(ctx, userJID, hash)
+	}
+}
+
+// Publish stamps the given jid's presence with the photo hash derived from
+// photoB64, storing it so it can be replayed on future presence broadcasts.
+// It's the entry point other modules (e.g. XEP-0084) use to push an avatar
+// update that originated outside of xep0054.
+func (x *VCardAvatar) Publish(ctx context.Context, userJID *jid.JID, photoB64 string) error {
+	hash, err := hashPhoto(photoB64)
+	if err != nil {
+		return err
+	}
+	if err := storage.InsertOrUpdateVCardAvatarHash(userJID.Node(), hash); err != nil {
+		return err
+	}
+	x.sendPresenceUpdate(ctx, userJID, hash)
+	return nil
+}
+
+// Retract clears the stored avatar hash for userJID and announces the
+// removal, per XEP-0153, by broadcasting an empty <photo/> element.
+func (x *VCardAvatar) Retract(ctx context.Context, userJID *jid.JID) error {
+	if err := storage.InsertOrUpdateVCardAvatarHash(userJID.Node(), ""); err != nil {
+		return err
+	}
+	x.sendPresenceUpdate(ctx, userJID, "")
+	return nil
+}
+
+// AvatarHash returns the currently stored XEP-0153 photo hash for userJID.
+// found is false when the hash hasn't been computed yet (e.g. the user has
+// never set a vCard, or the hash computation triggered by a recent vCard
+// update hasn't completed), in which case hash must be ignored.
+func AvatarHash(userJID *jid.JID) (hash string, found bool, err error) {
+	return storage.FetchVCardAvatarHash(userJID.Node())
+}
+
+// StampInitialPresence decorates presence with the user's current avatar
+// hash. The c2s router's bind handling is outside this module's package
+// (not part of this change set); wiring a call to StampInitialPresence
+// in right after a stream binds its resource, so the very first presence
+// the user broadcasts already carries the vcard-temp:x:update element, is
+// still pending and must land alongside that router change. Per
+// XEP-0153, when the hash isn't known yet the element is omitted
+// entirely rather than sent empty.
+func (x *VCardAvatar) StampInitialPresence(ctx context.Context, presence *xmpp.Presence) *xmpp.Presence {
+	hash, found, err := AvatarHash(presence.FromJID())
+	if err != nil {
+		log.Error(err)
+		return presence
+	}
+	if !found {
+		return presence
+	}
+	return decoratePresence(presence, hash)
+}
+
+func (x *VCardAvatar) sendPresenceUpdate(ctx context.Context, userJID *jid.JID, hash string) {
+	if x.router == nil {
+		return
+	}
+	for _, presence := range x.router.PresencesMatchingJID(userJID.ToBareJID()) {
+		x.router.Route(ctx, decoratePresence(presence, hash))
+	}
+}
+
+// decoratePresence returns a copy of presence with its vcard-temp:x:update
+// child replaced to reflect hash. An empty hash still yields an explicit,
+// empty <photo/> element — only a genuinely unknown hash omits it.
+func decoratePresence(presence *xmpp.Presence, hash string) *xmpp.Presence {
+	b := xmpp.NewElementFromElement(presence)
+	b.RemoveElementsNamespace("x", vCardUpdateNamespace)
+
+	x := xmpp.NewElementNamespace("x", vCardUpdateNamespace)
+	photo := xmpp.NewElementName("photo")
+	photo.SetText(hash)
+	x.AppendElement(photo)
+	b.AppendElement(x)
+
+	p, _ := xmpp.NewPresenceFromElement(b, presence.FromJID(), presence.ToJID())
+	return p
+}
+
+func hashPhoto(photoB64 string) (string, error) {
+	if len(photoB64) == 0 {
+		return "", nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(photoB64)
+	if err != nil {
+		return "", err
+	}
+	sum := sha1.Sum(raw)
+	return hex.EncodeToString(sum[:]), nil
+}
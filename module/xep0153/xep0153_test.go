@@ -0,0 +1,155 @@
+/*
+ * Copyright (c) 2018 Miguel Ángel Ortuño.
+ * See the LICENSE file for more information.
+ */
+
+package xep0153
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"github.com/ortuman/jackal/storage"
+	"github.com/ortuman/jackal/xmpp"
+	"github.com/ortuman/jackal/xmpp/jid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestXEP0153_HashPhoto(t *testing.T) {
+	hash, err := hashPhoto("")
+	require.NoError(t, err)
+	require.Equal(t, "", hash)
+
+	raw := []byte("fake-image-bytes")
+	sum := sha1.Sum(raw)
+	want := hex.EncodeToString(sum[:])
+
+	hash, err = hashPhoto(base64.StdEncoding.EncodeToString(raw))
+	require.NoError(t, err)
+	require.Equal(t, want, hash)
+
+	_, err = hashPhoto("not-valid-base64!!")
+	require.Error(t, err)
+}
+
+func TestXEP0153_DecoratePresence(t *testing.T) {
+	j, _ := jid.New("ortuman", "jackal.im", "balcony", true)
+	presence := xmpp.NewPresence(j, j.ToBareJID(), xmpp.AvailableType)
+
+	decorated := decoratePresence(presence, "somehash")
+	x := decorated.Elements().ChildNamespace("x", vCardUpdateNamespace)
+	require.NotNil(t, x)
+	require.Equal(t, "somehash", x.Elements().Child("photo").Text())
+
+	// re-decorating must replace the previous x element rather than
+	// appending a second one.
+	decorated = decoratePresence(decorated, "otherhash")
+	require.Len(t, decorated.Elements().ChildrenNamespace("x", vCardUpdateNamespace), 1)
+	x = decorated.Elements().ChildNamespace("x", vCardUpdateNamespace)
+	require.Equal(t, "otherhash", x.Elements().Child("photo").Text())
+
+	// per XEP-0153, an empty hash is still stamped as an explicit, empty
+	// <photo/> element.
+	decorated = decoratePresence(presence, "")
+	x = decorated.Elements().ChildNamespace("x", vCardUpdateNamespace)
+	require.NotNil(t, x)
+	require.NotNil(t, x.Elements().Child("photo"))
+	require.Equal(t, "", x.Elements().Child("photo").Text())
+}
+
+func TestXEP0153_StampInitialPresence_NotFound(t *testing.T) {
+	storage.Initialize(&storage.Config{Type: storage.Memory})
+	defer storage.Shutdown()
+
+	j, _ := jid.New("ortuman", "jackal.im", "balcony", true)
+	presence := xmpp.NewPresence(j, j.ToBareJID(), xmpp.AvailableType)
+
+	x := New(nil, nil)
+	got := x.StampInitialPresence(context.Background(), presence)
+
+	require.Nil(t, got.Elements().ChildNamespace("x", vCardUpdateNamespace))
+}
+
+func TestXEP0153_StampInitialPresence_Found(t *testing.T) {
+	storage.Initialize(&storage.Config{Type: storage.Memory})
+	defer storage.Shutdown()
+
+	j, _ := jid.New("ortuman", "jackal.im", "balcony", true)
+	presence := xmpp.NewPresence(j, j.ToBareJID(), xmpp.AvailableType)
+
+	x := New(nil, nil)
+
+	raw := []byte("fake-image-bytes")
+	photoB64 := base64.StdEncoding.EncodeToString(raw)
+	require.NoError(t, x.Publish(context.Background(), j, photoB64))
+
+	got := x.StampInitialPresence(context.Background(), presence)
+
+	sum := sha1.Sum(raw)
+	want := hex.EncodeToString(sum[:])
+	xEl := got.Elements().ChildNamespace("x", vCardUpdateNamespace)
+	require.NotNil(t, xEl)
+	require.Equal(t, want, xEl.Elements().Child("photo").Text())
+}
+
+func TestXEP0153_PublishAndRetract(t *testing.T) {
+	storage.Initialize(&storage.Config{Type: storage.Memory})
+	defer storage.Shutdown()
+
+	j, _ := jid.New("ortuman", "jackal.im", "balcony", true)
+	x := New(nil, nil)
+
+	_, found, err := AvatarHash(j)
+	require.NoError(t, err)
+	require.False(t, found)
+
+	raw := []byte("fake-image-bytes")
+	photoB64 := base64.StdEncoding.EncodeToString(raw)
+	require.NoError(t, x.Publish(context.Background(), j, photoB64))
+
+	hash, found, err := AvatarHash(j)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.NotEmpty(t, hash)
+
+	require.NoError(t, x.Retract(context.Background(), j))
+
+	hash, found, err = AvatarHash(j)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, "", hash)
+}
+
+type mockPep struct {
+	userJID *jid.JID
+	hash    string
+}
+
+func (m *mockPep) AvatarHashUpdated(ctx context.Context, userJID *jid.JID, hash string) {
+	m.userJID = userJID
+	m.hash = hash
+}
+
+func TestXEP0153_PublishAvatar_NotifiesPep(t *testing.T) {
+	storage.Initialize(&storage.Config{Type: storage.Memory})
+	defer storage.Shutdown()
+
+	j, _ := jid.New("ortuman", "jackal.im", "balcony", true)
+	pep := &mockPep{}
+	x := New(nil, pep)
+
+	raw := []byte("fake-image-bytes")
+	photoB64 := base64.StdEncoding.EncodeToString(raw)
+
+	// publishAvatar is exercised directly (bypassing the runQueue-backed
+	// PublishAvatar) to keep the assertion deterministic.
+	x.publishAvatar(context.Background(), j, photoB64)
+
+	sum := sha1.Sum(raw)
+	want := hex.EncodeToString(sum[:])
+	require.Equal(t, j, pep.userJID)
+	require.Equal(t, want, pep.hash)
+}